@@ -16,19 +16,38 @@ package cmd
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
 	"github.com/karimra/gnmic/collector"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmi/proto/gnmi_ext"
 	"github.com/spf13/viper"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
 
 	"github.com/spf13/cobra"
 )
 
-var printVersion bool
+// cloudEventsCapabilitiesType is the CloudEvents `type` attribute set on
+// events produced from a gNMI CapabilityResponse.
+const cloudEventsCapabilitiesType = "dev.gnmic.capabilities.response"
+
+var (
+	printVersion bool
+
+	extHistorySnapshotTime string
+	extHistoryRange        string
+	extRegisteredExt       []string
+)
 
 // capabilitiesCmd represents the capabilities command
 var capabilitiesCmd = &cobra.Command{
@@ -67,9 +86,13 @@ func reqCapability(ctx context.Context, target *collector.Target, wg *sync.WaitG
 		logger.Printf("failed to create a gRPC client for target '%s' : %v", target.Config.Name, err)
 		return
 	}
-	ext := make([]*gnmi_ext.Extension, 0) //
+	ext, err := buildCapabilityExtensions()
+	if err != nil {
+		logger.Printf("failed to build gNMI CapabilityRequest extensions: %v", err)
+		return
+	}
 	logger.Printf("sending gNMI CapabilityRequest: gnmi_ext.Extension='%v' to %s", ext, target.Config.Address)
-	response, err := target.Capabilities(ctx)
+	response, err := target.Capabilities(ctx, ext...)
 	if err != nil {
 		logger.Printf("error sending capabilities request: %v", err)
 		return
@@ -90,6 +113,15 @@ func printCapResponse(r *gnmi.CapabilityResponse, address string) {
 		fmt.Printf("%s\n", indent(printPrefix, prototext.Format(r)))
 		return
 	}
+	if viper.GetString("format") == "cloudevents" {
+		b, err := capabilitiesCloudEvent(r, address)
+		if err != nil {
+			logger.Printf("failed to build cloudevents envelope for %q: %v", address, err)
+			return
+		}
+		fmt.Printf("%s%s\n", printPrefix, string(b))
+		return
+	}
 	fmt.Printf("%sgNMI version: %s\n", printPrefix, r.GNMIVersion)
 	if viper.GetBool("version") {
 		return
@@ -102,11 +134,162 @@ func printCapResponse(r *gnmi.CapabilityResponse, address string) {
 	for _, se := range r.SupportedEncodings {
 		fmt.Printf("%s  - %s\n", printPrefix, se.String())
 	}
+	if len(r.Extension) > 0 {
+		fmt.Printf("%sextensions:\n", printPrefix)
+		for _, ext := range r.Extension {
+			fmt.Printf("%s  - %s\n", printPrefix, formatExtension(ext))
+		}
+	}
 	fmt.Println()
 }
 
+// formatExtension renders a gnmi_ext.Extension in a human-readable form,
+// decoding the well-known History extension into its snapshot-time/range
+// fields rather than falling back to its raw proto representation.
+func formatExtension(ext *gnmi_ext.Extension) string {
+	switch v := ext.GetExt().(type) {
+	case *gnmi_ext.Extension_History:
+		switch r := v.History.GetRequest().(type) {
+		case *gnmi_ext.History_SnapshotTime:
+			return fmt.Sprintf("history snapshot-time=%s", time.Unix(0, r.SnapshotTime).UTC().Format(time.RFC3339Nano))
+		case *gnmi_ext.History_Range:
+			return fmt.Sprintf("history range=[%s, %s]",
+				time.Unix(0, r.Range.GetStart()).UTC().Format(time.RFC3339Nano),
+				time.Unix(0, r.Range.GetEnd()).UTC().Format(time.RFC3339Nano))
+		}
+		return "history"
+	case *gnmi_ext.Extension_RegisteredExt:
+		return fmt.Sprintf("registered-ext id=%d msg=%dB", v.RegisteredExt.GetId(), len(v.RegisteredExt.GetMsg()))
+	default:
+		return ext.String()
+	}
+}
+
+// capabilitiesCloudEvent serializes r as a CloudEvents v1.0 structured-mode
+// JSON envelope, with address as the event source, so the response can be
+// ingested directly by Knative/Keda/EventBridge-style consumers without a
+// translator. It can't reuse formatters.ToCloudEvent since r is a
+// gnmi.CapabilityResponse, not a formatters.EventMsg, so it builds its own
+// envelope the same way, with its own event type.
+func capabilitiesCloudEvent(r *gnmi.CapabilityResponse, address string) ([]byte, error) {
+	data, err := protojson.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	event := cloudevents.NewEvent()
+	event.SetID(uuid.New().String())
+	event.SetSource(address)
+	event.SetType(cloudEventsCapabilitiesType)
+	event.SetTime(time.Now())
+	if err := event.SetData("application/json", json.RawMessage(data)); err != nil {
+		return nil, err
+	}
+	return event.MarshalJSON()
+}
+
+// buildCapabilityExtensions builds the gnmi_ext.Extension messages to attach
+// to the outgoing CapabilityRequest from the --ext-* flags.
+func buildCapabilityExtensions() ([]*gnmi_ext.Extension, error) {
+	exts := make([]*gnmi_ext.Extension, 0)
+	snapshotTime := viper.GetString("capabilities-ext-history-snapshot-time")
+	if snapshotTime != "" {
+		t, err := time.Parse(time.RFC3339, snapshotTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ext-history-snapshot-time %q: %v", snapshotTime, err)
+		}
+		exts = append(exts, &gnmi_ext.Extension{
+			Ext: &gnmi_ext.Extension_History{
+				History: &gnmi_ext.History{
+					Request: &gnmi_ext.History_SnapshotTime{SnapshotTime: t.UnixNano()},
+				},
+			},
+		})
+	}
+	historyRange := viper.GetString("capabilities-ext-history-range")
+	if historyRange != "" {
+		parts := strings.SplitN(historyRange, ",", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid --ext-history-range %q, expected '<start-RFC3339>,<end-RFC3339>'", historyRange)
+		}
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ext-history-range start %q: %v", parts[0], err)
+		}
+		end, err := time.Parse(time.RFC3339, strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ext-history-range end %q: %v", parts[1], err)
+		}
+		exts = append(exts, &gnmi_ext.Extension{
+			Ext: &gnmi_ext.Extension_History{
+				History: &gnmi_ext.History{
+					Request: &gnmi_ext.History_Range{
+						Range: &gnmi_ext.TimeRange{Start: start.UnixNano(), End: end.UnixNano()},
+					},
+				},
+			},
+		})
+	}
+	for _, re := range viper.GetStringSlice("capabilities-ext-registered-ext") {
+		ext, err := parseRegisteredExtension(re)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// parseRegisteredExtension parses a "id=<uint>,msg=<base64>" flag value into
+// a gnmi_ext.Extension carrying a RegisteredExtension.
+func parseRegisteredExtension(s string) (*gnmi_ext.Extension, error) {
+	var id uint64
+	var msg []byte
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --ext-registered-ext %q, expected 'id=<uint>,msg=<base64>'", s)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "id":
+			// gnmi_ext.ExtensionID is an int32 underneath, so cap id at
+			// math.MaxInt32 rather than letting it silently wrap negative.
+			parsed, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --ext-registered-ext id %q: %v", v, err)
+			}
+			if parsed > math.MaxInt32 {
+				return nil, fmt.Errorf("invalid --ext-registered-ext id %q: must be <= %d", v, math.MaxInt32)
+			}
+			id = parsed
+		case "msg":
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --ext-registered-ext msg %q: %v", v, err)
+			}
+			msg = decoded
+		default:
+			return nil, fmt.Errorf("invalid --ext-registered-ext key %q", k)
+		}
+	}
+	return &gnmi_ext.Extension{
+		Ext: &gnmi_ext.Extension_RegisteredExt{
+			RegisteredExt: &gnmi_ext.RegisteredExtension{
+				Id:  gnmi_ext.ExtensionID(id),
+				Msg: msg,
+			},
+		},
+	}, nil
+}
+
 func init() {
 	rootCmd.AddCommand(capabilitiesCmd)
 	capabilitiesCmd.Flags().BoolVarP(&printVersion, "version", "", false, "show gnmi version only")
 	viper.BindPFlag("capabilities-version", capabilitiesCmd.LocalFlags().Lookup("version"))
+	capabilitiesCmd.Flags().StringVar(&extHistorySnapshotTime, "ext-history-snapshot-time", "", "attach a History snapshot-time extension (RFC3339) to the CapabilityRequest")
+	viper.BindPFlag("capabilities-ext-history-snapshot-time", capabilitiesCmd.LocalFlags().Lookup("ext-history-snapshot-time"))
+	capabilitiesCmd.Flags().StringVar(&extHistoryRange, "ext-history-range", "", "attach a History range extension to the CapabilityRequest, as '<start-RFC3339>,<end-RFC3339>'")
+	viper.BindPFlag("capabilities-ext-history-range", capabilitiesCmd.LocalFlags().Lookup("ext-history-range"))
+	capabilitiesCmd.Flags().StringArrayVar(&extRegisteredExt, "ext-registered-ext", []string{}, "attach a registered extension to the CapabilityRequest, as 'id=<uint>,msg=<base64>' (repeatable)")
+	viper.BindPFlag("capabilities-ext-registered-ext", capabilitiesCmd.LocalFlags().Lookup("ext-registered-ext"))
 }
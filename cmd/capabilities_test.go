@@ -0,0 +1,160 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"github.com/spf13/viper"
+)
+
+func TestParseRegisteredExtension(t *testing.T) {
+	msg := base64.StdEncoding.EncodeToString([]byte("hello"))
+	ext, err := parseRegisteredExtension("id=42,msg=" + msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	re, ok := ext.GetExt().(*gnmi_ext.Extension_RegisteredExt)
+	if !ok {
+		t.Fatalf("expected a RegisteredExt, got %T", ext.GetExt())
+	}
+	if re.RegisteredExt.GetId() != 42 {
+		t.Fatalf("expected id 42, got %d", re.RegisteredExt.GetId())
+	}
+	if string(re.RegisteredExt.GetMsg()) != "hello" {
+		t.Fatalf("expected msg %q, got %q", "hello", re.RegisteredExt.GetMsg())
+	}
+}
+
+func TestParseRegisteredExtension_BadID(t *testing.T) {
+	if _, err := parseRegisteredExtension("id=notanumber,msg=aGk="); err == nil {
+		t.Fatalf("expected an error for a non-numeric id")
+	}
+}
+
+func TestParseRegisteredExtension_IDOverflowsInt32(t *testing.T) {
+	// gnmi_ext.ExtensionID is an int32; an id past math.MaxInt32 must be
+	// rejected rather than silently wrapping negative.
+	if _, err := parseRegisteredExtension("id=4294967295,msg=aGk="); err == nil {
+		t.Fatalf("expected an error for an id overflowing int32")
+	}
+}
+
+func TestParseRegisteredExtension_BadBase64(t *testing.T) {
+	if _, err := parseRegisteredExtension("id=1,msg=not-base64!!"); err == nil {
+		t.Fatalf("expected an error for an invalid base64 msg")
+	}
+}
+
+func TestParseRegisteredExtension_MissingEquals(t *testing.T) {
+	if _, err := parseRegisteredExtension("id=1,notakeyvalue"); err == nil {
+		t.Fatalf("expected an error for a part with no '='")
+	}
+}
+
+func TestParseRegisteredExtension_UnknownKey(t *testing.T) {
+	if _, err := parseRegisteredExtension("bogus=1"); err == nil {
+		t.Fatalf("expected an error for an unknown key")
+	}
+}
+
+func TestBuildCapabilityExtensions_SnapshotTime(t *testing.T) {
+	defer viper.Set("capabilities-ext-history-snapshot-time", "")
+	ts := "2023-01-02T15:04:05Z"
+	viper.Set("capabilities-ext-history-snapshot-time", ts)
+
+	exts, err := buildCapabilityExtensions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exts) != 1 {
+		t.Fatalf("expected 1 extension, got %d", len(exts))
+	}
+	h, ok := exts[0].GetExt().(*gnmi_ext.Extension_History)
+	if !ok {
+		t.Fatalf("expected a History extension, got %T", exts[0].GetExt())
+	}
+	st, ok := h.History.GetRequest().(*gnmi_ext.History_SnapshotTime)
+	if !ok {
+		t.Fatalf("expected a SnapshotTime request, got %T", h.History.GetRequest())
+	}
+	want, _ := time.Parse(time.RFC3339, ts)
+	if st.SnapshotTime != want.UnixNano() {
+		t.Fatalf("expected snapshot time %d, got %d", want.UnixNano(), st.SnapshotTime)
+	}
+}
+
+func TestBuildCapabilityExtensions_Range(t *testing.T) {
+	defer viper.Set("capabilities-ext-history-range", "")
+	viper.Set("capabilities-ext-history-range", "2023-01-01T00:00:00Z,2023-01-02T00:00:00Z")
+
+	exts, err := buildCapabilityExtensions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exts) != 1 {
+		t.Fatalf("expected 1 extension, got %d", len(exts))
+	}
+	h, ok := exts[0].GetExt().(*gnmi_ext.Extension_History)
+	if !ok {
+		t.Fatalf("expected a History extension, got %T", exts[0].GetExt())
+	}
+	if _, ok := h.History.GetRequest().(*gnmi_ext.History_Range); !ok {
+		t.Fatalf("expected a Range request, got %T", h.History.GetRequest())
+	}
+}
+
+func TestBuildCapabilityExtensions_InvalidRange(t *testing.T) {
+	defer viper.Set("capabilities-ext-history-range", "")
+	viper.Set("capabilities-ext-history-range", "not-a-range")
+
+	if _, err := buildCapabilityExtensions(); err == nil {
+		t.Fatalf("expected an error for a malformed range")
+	}
+}
+
+func TestFormatExtension(t *testing.T) {
+	snapshot := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		ext  *gnmi_ext.Extension
+		want string
+	}{
+		{
+			name: "history snapshot-time",
+			ext: &gnmi_ext.Extension{Ext: &gnmi_ext.Extension_History{History: &gnmi_ext.History{
+				Request: &gnmi_ext.History_SnapshotTime{SnapshotTime: snapshot.UnixNano()},
+			}}},
+			want: "history snapshot-time=" + snapshot.Format(time.RFC3339Nano),
+		},
+		{
+			name: "registered-ext",
+			ext: &gnmi_ext.Extension{Ext: &gnmi_ext.Extension_RegisteredExt{RegisteredExt: &gnmi_ext.RegisteredExtension{
+				Id: 7, Msg: []byte("abc"),
+			}}},
+			want: "registered-ext id=7 msg=3B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatExtension(tt.ext); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
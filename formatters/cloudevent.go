@@ -0,0 +1,51 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package formatters
+
+import (
+	"encoding/json"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// EventMsgCloudEventsType is the CloudEvents `type` attribute set on events
+// produced from an EventMsg.
+const EventMsgCloudEventsType = "dev.gnmic.formatters.event"
+
+// ToCloudEvent serializes e as a CloudEvents v1.0 structured-mode JSON
+// envelope, with source as the event source, so it can be ingested directly
+// by Knative/Keda/EventBridge-style consumers without a translator.
+//
+// `gnmic capabilities --format cloudevents` does not use it: cmd/capabilities.go
+// builds its own envelope for a gnmi.CapabilityResponse. No outputs.Output
+// implementation calls it, so subscribe/get notifications are not emitted as
+// CloudEvents.
+func ToCloudEvent(e *EventMsg, source string) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	event := cloudevents.NewEvent()
+	event.SetID(uuid.New().String())
+	event.SetSource(source)
+	event.SetType(EventMsgCloudEventsType)
+	event.SetTime(time.Now())
+	if err := event.SetData("application/json", json.RawMessage(data)); err != nil {
+		return nil, err
+	}
+	return event.MarshalJSON()
+}
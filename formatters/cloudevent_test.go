@@ -0,0 +1,53 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package formatters
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToCloudEvent(t *testing.T) {
+	e := &EventMsg{
+		Name:      "test",
+		Timestamp: 1234,
+		Tags:      map[string]string{"source": "r1"},
+		Values:    map[string]interface{}{"value": 1},
+	}
+	b, err := ToCloudEvent(e, "r1")
+	if err != nil {
+		t.Fatalf("ToCloudEvent failed: %v", err)
+	}
+	var env map[string]interface{}
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatalf("expected valid JSON envelope, got error: %v", err)
+	}
+	if env["source"] != "r1" {
+		t.Fatalf("expected source %q, got %v", "r1", env["source"])
+	}
+	if env["type"] != EventMsgCloudEventsType {
+		t.Fatalf("expected type %q, got %v", EventMsgCloudEventsType, env["type"])
+	}
+	if env["id"] == "" || env["id"] == nil {
+		t.Fatalf("expected a non-empty event id")
+	}
+	data, ok := env["data"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected data to be the marshaled EventMsg, got %v", env["data"])
+	}
+	if data["name"] != "test" {
+		t.Fatalf("expected event data to carry the EventMsg fields, got %v", data)
+	}
+}
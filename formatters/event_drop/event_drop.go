@@ -9,31 +9,61 @@
 package event_drop
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/itchyny/gojq"
+	"github.com/open-policy-agent/opa/rego"
 	"github.com/openconfig/gnmic/formatters"
 	"github.com/openconfig/gnmic/types"
 	"github.com/openconfig/gnmic/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	processorType = "event-drop"
 	loggingPrefix = "[" + processorType + "] "
+	// policyQuery is the rego query evaluated against each event, the
+	// event is dropped whenever it evaluates to true.
+	policyQuery = "data.gnmic.drop"
+	// bucketIdleTTL is how long a per-key rate bucket can sit unused
+	// before it is garbage collected.
+	bucketIdleTTL = 10 * time.Minute
+	// targetTagName is the EventMsg tag carrying the originating
+	// target's name, used as the target label of the dropped-events metric.
+	targetTagName = "source"
 )
 
-// Drop Drops the msg if ANY of the Tags or Values regexes are matched
+// Drop Drops the msg if ANY of the Tags or Values regexes are matched, or if
+// Condition/Policy evaluates to true.
+//
+// The checks are applied in order: Condition/Policy, regex matches, Invert,
+// Sample, Rate. Invert flips the match decision so that only matching events
+// are kept. Sample and Rate then further throttle the events that would be
+// dropped: Sample drops a fraction of them, Rate drops only the ones that
+// exceed a per-key budget.
 type Drop struct {
 	Condition  string   `mapstructure:"condition,omitempty"`
+	Policy     string   `mapstructure:"policy,omitempty" json:"policy,omitempty"`
 	TagNames   []string `mapstructure:"tag-names,omitempty" json:"tag-names,omitempty"`
 	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
 	Tags       []string `mapstructure:"tags,omitempty" json:"tags,omitempty"`
 	Values     []string `mapstructure:"values,omitempty" json:"values,omitempty"`
+	Invert     bool     `mapstructure:"invert,omitempty" json:"invert,omitempty"`
+	Sample     string   `mapstructure:"sample,omitempty" json:"sample,omitempty"`
+	Rate       string   `mapstructure:"rate,omitempty" json:"rate,omitempty"`
+	RateKeys   []string `mapstructure:"rate-keys,omitempty" json:"rate-keys,omitempty"`
 	Debug      bool     `mapstructure:"debug,omitempty" json:"debug,omitempty"`
 
 	tagNames   []*regexp.Regexp
@@ -41,7 +71,48 @@ type Drop struct {
 	tags       []*regexp.Regexp
 	values     []*regexp.Regexp
 	code       *gojq.Code
-	logger     *log.Logger
+	policy     *rego.PreparedEvalQuery
+
+	sampleProb    float64
+	sampleEvery   uint64
+	sampleCounter uint64
+
+	rateCapacity float64
+	rateRefill   float64
+	rateMu       sync.Mutex
+	rateBuckets  map[string]*tokenBucket
+
+	tracer trace.Tracer
+	logger *log.Logger
+	name   string
+}
+
+// tokenBucket is a simple token bucket rate limiter, keyed per tag set by
+// Drop.rateBuckets and protected by Drop.rateMu.
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	capacity   float64
+	refillRate float64 // tokens per second
+	lastRefill time.Time
+	lastUsed   time.Time
+}
+
+func (tb *tokenBucket) allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.lastRefill).Seconds() * tb.refillRate
+	if tb.tokens > tb.capacity {
+		tb.tokens = tb.capacity
+	}
+	tb.lastRefill = now
+	tb.lastUsed = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
 }
 
 func init() {
@@ -61,13 +132,73 @@ func (d *Drop) Init(cfg interface{}, opts ...formatters.Option) error {
 		opt(d)
 	}
 	d.Condition = strings.TrimSpace(d.Condition)
-	q, err := gojq.Parse(d.Condition)
-	if err != nil {
-		return err
+	if d.Condition != "" {
+		q, err := gojq.Parse(d.Condition)
+		if err != nil {
+			return err
+		}
+		d.code, err = gojq.Compile(q)
+		if err != nil {
+			return err
+		}
 	}
-	d.code, err = gojq.Compile(q)
-	if err != nil {
-		return err
+	d.Policy = strings.TrimSpace(d.Policy)
+	if d.Policy != "" {
+		pq, err := rego.New(
+			rego.Query(policyQuery),
+			rego.Module(processorType+".rego", d.Policy),
+		).PrepareForEval(context.Background())
+		if err != nil {
+			return err
+		}
+		d.policy = &pq
+	}
+	d.Sample = strings.TrimSpace(d.Sample)
+	if d.Sample != "" {
+		if idx := strings.Index(d.Sample, "/"); idx >= 0 {
+			num, err := strconv.ParseUint(d.Sample[:idx], 10, 64)
+			if err != nil {
+				return err
+			}
+			den, err := strconv.ParseUint(d.Sample[idx+1:], 10, 64)
+			if err != nil {
+				return err
+			}
+			if num != 1 || den == 0 {
+				return fmt.Errorf("invalid sample rate %q, expected '1/N'", d.Sample)
+			}
+			d.sampleEvery = den
+		} else {
+			f, err := strconv.ParseFloat(d.Sample, 64)
+			if err != nil {
+				return err
+			}
+			if f < 0 || f > 1 {
+				return fmt.Errorf("invalid sample rate %q, expected a value between 0 and 1", d.Sample)
+			}
+			d.sampleProb = f
+		}
+	}
+	d.Rate = strings.TrimSpace(d.Rate)
+	if d.Rate != "" {
+		idx := strings.Index(d.Rate, "/")
+		if idx < 0 {
+			return fmt.Errorf("invalid rate %q, expected 'N/duration'", d.Rate)
+		}
+		n, err := strconv.ParseFloat(d.Rate[:idx], 64)
+		if err != nil {
+			return err
+		}
+		dur, err := time.ParseDuration(d.Rate[idx+1:])
+		if err != nil {
+			return err
+		}
+		if n <= 0 || dur <= 0 {
+			return fmt.Errorf("invalid rate %q, expected positive N and duration", d.Rate)
+		}
+		d.rateCapacity = n
+		d.rateRefill = n / dur.Seconds()
+		d.rateBuckets = make(map[string]*tokenBucket)
 	}
 	// init tag keys regex
 	d.tagNames = make([]*regexp.Regexp, 0, len(d.TagNames))
@@ -116,61 +247,176 @@ func (d *Drop) Init(cfg interface{}, opts ...formatters.Option) error {
 }
 
 func (d *Drop) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	return d.ApplyContext(context.Background(), es...)
+}
+
+// ApplyContext is Apply, but starts its span (when a tracer is configured) as
+// a child of ctx instead of a new root, so that when d is wrapped by
+// formatters.Instrument, its span nests under the wrapper's processor.Apply
+// span rather than forming an unrelated trace. It satisfies
+// formatters.ContextualApplier.
+func (d *Drop) ApplyContext(ctx context.Context, es ...*formatters.EventMsg) []*formatters.EventMsg {
+	var span trace.Span
+	if d.tracer != nil {
+		_, span = d.tracer.Start(ctx, processorType+".apply")
+		defer span.End()
+	}
 	toDrop := make([]int, 0, len(es))
 	for i, e := range es {
 		if e == nil {
 			continue
 		}
-		if d.Condition != "" {
-			ok, err := formatters.CheckCondition(d.code, e)
-			if err != nil {
-				d.logger.Printf("condition check failed: %v", err)
-				continue
-			}
-			if ok {
-				toDrop = append(toDrop, i)
-				continue
-			}
+		drop, reason := d.shouldDrop(e)
+		if !drop {
+			continue
 		}
-		for k, v := range e.Values {
-			for _, re := range d.valueNames {
-				if re.MatchString(k) {
-					d.logger.Printf("value name '%s' matched regex '%s'", k, re.String())
-					toDrop = append(toDrop, i)
-					break
-				}
+		toDrop = append(toDrop, i)
+		formatters.RecordDropReason(processorType, d.name, e.Tags[targetTagName], reason, span)
+	}
+	if len(toDrop) == 0 {
+		return es
+	}
+	return shift(es, toDrop)
+}
+
+// shouldDrop runs, in order, the condition/policy/regex match, the Invert
+// flip, the Sample throttle and the Rate throttle, and reports whether e
+// should be dropped from the batch, along with the reason for the decision.
+// Invert is skipped when the Condition/Policy evaluation itself errored, so a
+// broken expression can't be inverted into "drop everything".
+func (d *Drop) shouldDrop(e *formatters.EventMsg) (bool, string) {
+	drop, reason, errored := d.matches(e)
+	if d.Invert && !errored {
+		drop = !drop
+	}
+	if drop && d.Sample != "" {
+		drop = d.sample()
+	}
+	if drop && d.Rate != "" {
+		drop = !d.allow(e)
+		if drop {
+			reason = "rate"
+		}
+	}
+	return drop, reason
+}
+
+// matches reports whether e matches the configured Condition, Policy, or any
+// of the Tag/Value regexes, along with the name of the check that matched.
+// The third return value reports whether a Condition/Policy evaluation
+// errored, as opposed to simply not matching, so callers can tell the two
+// apart.
+func (d *Drop) matches(e *formatters.EventMsg) (bool, string, bool) {
+	if d.Condition != "" {
+		ok, err := formatters.CheckCondition(d.code, e)
+		if err != nil {
+			d.logger.Printf("condition check failed: %v", err)
+			return false, "", true
+		}
+		if ok {
+			return true, "condition", false
+		}
+	}
+	if d.policy != nil {
+		ok, err := d.evalPolicy(e)
+		if err != nil {
+			d.logger.Printf("policy check failed: %v", err)
+			return false, "", true
+		}
+		if ok {
+			return true, "policy", false
+		}
+	}
+	for k, v := range e.Values {
+		for _, re := range d.valueNames {
+			if re.MatchString(k) {
+				d.logger.Printf("value name '%s' matched regex '%s'", k, re.String())
+				return true, "value-name", false
 			}
-			for _, re := range d.values {
-				if vs, ok := v.(string); ok {
-					if re.MatchString(vs) {
-						d.logger.Printf("value '%s' matched regex '%s'", v, re.String())
-						toDrop = append(toDrop, i)
-						break
-					}
+		}
+		for _, re := range d.values {
+			if vs, ok := v.(string); ok {
+				if re.MatchString(vs) {
+					d.logger.Printf("value '%s' matched regex '%s'", v, re.String())
+					return true, "value", false
 				}
 			}
 		}
-		for k, v := range e.Tags {
-			for _, re := range d.tagNames {
-				if re.MatchString(k) {
-					d.logger.Printf("tag name '%s' matched regex '%s'", k, re.String())
-					toDrop = append(toDrop, i)
-					break
-				}
+	}
+	for k, v := range e.Tags {
+		for _, re := range d.tagNames {
+			if re.MatchString(k) {
+				d.logger.Printf("tag name '%s' matched regex '%s'", k, re.String())
+				return true, "tag-name", false
 			}
-			for _, re := range d.tags {
-				if re.MatchString(v) {
-					d.logger.Printf("tag '%s' matched regex '%s'", v, re.String())
-					toDrop = append(toDrop, i)
-					break
-				}
+		}
+		for _, re := range d.tags {
+			if re.MatchString(v) {
+				d.logger.Printf("tag '%s' matched regex '%s'", v, re.String())
+				return true, "tag-value", false
 			}
 		}
 	}
-	if len(toDrop) == 0 {
-		return es
+	return false, "", false
+}
+
+// sample decides, for an event that already matched, whether it actually
+// gets dropped: a "1/N" Sample drops deterministically every Nth match, a
+// fractional Sample drops with that probability.
+func (d *Drop) sample() bool {
+	if d.sampleEvery > 0 {
+		n := atomic.AddUint64(&d.sampleCounter, 1)
+		return n%d.sampleEvery == 0
+	}
+	return rand.Float64() < d.sampleProb
+}
+
+// allow consults the token bucket for e's rate key and reports whether e
+// falls within the configured Rate budget.
+func (d *Drop) allow(e *formatters.EventMsg) bool {
+	key := d.rateKey(e)
+	d.rateMu.Lock()
+	defer d.rateMu.Unlock()
+	d.gcBuckets()
+	tb, ok := d.rateBuckets[key]
+	if !ok {
+		tb = &tokenBucket{
+			tokens:     d.rateCapacity,
+			capacity:   d.rateCapacity,
+			refillRate: d.rateRefill,
+			lastRefill: time.Now(),
+			lastUsed:   time.Now(),
+		}
+		d.rateBuckets[key] = tb
+	}
+	return tb.allow()
+}
+
+// rateKey builds the token bucket key for e out of the configured RateKeys
+// tag names. An empty RateKeys means a single, global bucket.
+func (d *Drop) rateKey(e *formatters.EventMsg) string {
+	if len(d.RateKeys) == 0 {
+		return ""
+	}
+	parts := make([]string, len(d.RateKeys))
+	for i, k := range d.RateKeys {
+		parts[i] = e.Tags[k]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// gcBuckets deletes rate buckets that have been idle for more than
+// bucketIdleTTL. Callers must hold d.rateMu.
+func (d *Drop) gcBuckets() {
+	now := time.Now()
+	for k, tb := range d.rateBuckets {
+		tb.mu.Lock()
+		idle := now.Sub(tb.lastUsed)
+		tb.mu.Unlock()
+		if idle > bucketIdleTTL {
+			delete(d.rateBuckets, k)
+		}
 	}
-	return shift(es, toDrop)
 }
 
 func (d *Drop) WithLogger(l *log.Logger) {
@@ -181,6 +427,44 @@ func (d *Drop) WithLogger(l *log.Logger) {
 	}
 }
 
+// evalPolicy runs the prepared Rego query against e and reports whether the
+// event should be dropped.
+func (d *Drop) evalPolicy(e *formatters.EventMsg) (bool, error) {
+	rs, err := d.policy.Eval(context.Background(), rego.EvalInput(map[string]interface{}{
+		"name":      e.Name,
+		"timestamp": e.Timestamp,
+		"tags":      e.Tags,
+		"values":    e.Values,
+		"deletes":   e.Deletes,
+	}))
+	if err != nil {
+		return false, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return false, nil
+	}
+	drop, _ := rs[0].Expressions[0].Value.(bool)
+	return drop, nil
+}
+
+// WithTracer sets the tracer used to wrap Apply in a span, it is called by
+// formatters.WithTracer when a tracer was injected at Init time.
+func (d *Drop) WithTracer(t trace.Tracer) {
+	d.tracer = t
+}
+
+// WithName captures the processor instance's configured name so its
+// self-reported drops can be labeled with it. It is called by
+// formatters.WithName when a name was injected at Init time.
+func (d *Drop) WithName(name string) {
+	d.name = name
+}
+
+// SelfReportsDropReasons tells the instrumentedProcessor wrapper that Drop
+// already records its own dropped events, with their actual reason, via
+// formatters.RecordDropReason, so the wrapper must not count them again.
+func (d *Drop) SelfReportsDropReasons() bool { return true }
+
 func (d *Drop) WithTargets(tcs map[string]*types.TargetConfig) {}
 
 func (d *Drop) WithActions(act map[string]map[string]interface{}) {}
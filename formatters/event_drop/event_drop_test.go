@@ -0,0 +1,155 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event_drop
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/openconfig/gnmic/formatters"
+)
+
+func newTestDrop(t *testing.T, d *Drop) *Drop {
+	t.Helper()
+	d.logger = log.New(io.Discard, "", 0)
+	if err := d.Init(d); err != nil {
+		t.Fatalf("failed to init drop processor: %v", err)
+	}
+	return d
+}
+
+func TestDrop_Invert(t *testing.T) {
+	d := newTestDrop(t, &Drop{TagNames: []string{"^keep$"}, Invert: true})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"keep": "1"}},
+		{Tags: map[string]string{"drop-me": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 1 || out[0] != es[0] {
+		t.Fatalf("expected only the matching event to be kept, got %v", out)
+	}
+}
+
+func TestDrop_Invert_ConditionErrorKeeps(t *testing.T) {
+	// a Condition evaluation error must not be invertible into "drop
+	// everything": Invert is skipped and the event is kept, whereas a plain
+	// non-match would be inverted into a drop.
+	d := newTestDrop(t, &Drop{Condition: `error("boom")`, Invert: true})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"keep": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 1 {
+		t.Fatalf("expected the event to survive a Condition evaluation error, got %v", out)
+	}
+}
+
+func TestDrop_Sample_DeterministicEveryN(t *testing.T) {
+	d := newTestDrop(t, &Drop{TagNames: []string{"^x$"}, Sample: "1/3"})
+	es := make([]*formatters.EventMsg, 6)
+	for i := range es {
+		es[i] = &formatters.EventMsg{Tags: map[string]string{"x": "1"}}
+	}
+	out := d.Apply(es...)
+	// one in every three matches is dropped: 6 matches -> 2 dropped -> 4 kept.
+	if len(out) != 4 {
+		t.Fatalf("expected 4 events to survive sampling, got %d", len(out))
+	}
+}
+
+func TestDrop_Rate_EmptyRateKeys(t *testing.T) {
+	// with no RateKeys, all events share a single bucket regardless of tags.
+	d := newTestDrop(t, &Drop{TagNames: []string{"^x$"}, Rate: "2/1h"})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"x": "1"}},
+		{Tags: map[string]string{"x": "1"}},
+		{Tags: map[string]string{"x": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 2 {
+		t.Fatalf("expected the bucket to admit only 2 of 3 events, got %d", len(out))
+	}
+}
+
+func TestDrop_Policy_InvalidRegoFailsInit(t *testing.T) {
+	d := &Drop{Policy: "not valid rego"}
+	d.logger = log.New(io.Discard, "", 0)
+	if err := d.Init(d); err == nil {
+		t.Fatalf("expected Init to fail compiling an invalid policy, got nil error")
+	}
+}
+
+func TestDrop_Policy_DropsOnTrue(t *testing.T) {
+	d := newTestDrop(t, &Drop{Policy: `package gnmic
+
+drop { input.tags["drop-me"] == "1" }`})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"drop-me": "1"}},
+		{Tags: map[string]string{"keep": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 1 || out[0] != es[1] {
+		t.Fatalf("expected only the non-matching event to be kept, got %v", out)
+	}
+}
+
+func TestDrop_Policy_UndefinedResultKeeps(t *testing.T) {
+	// the policy never sets `drop` for this input, so the rego result is
+	// undefined and the event must be kept, not dropped.
+	d := newTestDrop(t, &Drop{Policy: `package gnmic
+
+drop { input.tags["drop-me"] == "1" }`})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"keep": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 1 {
+		t.Fatalf("expected the event to survive an undefined policy result, got %v", out)
+	}
+}
+
+func TestDrop_Policy_NonBoolResultKeeps(t *testing.T) {
+	// the policy resolves to a non-bool value; evalPolicy's type assertion
+	// fails open (drop=false) rather than treating it as truthy.
+	d := newTestDrop(t, &Drop{Policy: `package gnmic
+
+drop = "yes"`})
+	es := []*formatters.EventMsg{
+		{Tags: map[string]string{"keep": "1"}},
+	}
+	out := d.Apply(es...)
+	if len(out) != 1 {
+		t.Fatalf("expected the event to survive a non-bool policy result, got %v", out)
+	}
+}
+
+func TestDrop_Rate_ConcurrentApply(t *testing.T) {
+	d := newTestDrop(t, &Drop{TagNames: []string{"^x$"}, Rate: "50/1h", RateKeys: []string{"target"}})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			es := []*formatters.EventMsg{
+				{Tags: map[string]string{"x": "1", "target": "t1"}},
+				{Tags: map[string]string{"x": "1", "target": "t2"}},
+			}
+			d.Apply(es...)
+		}()
+	}
+	wg.Wait()
+}
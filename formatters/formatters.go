@@ -0,0 +1,132 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package formatters defines the EventProcessor interface implemented by the
+// formatters/event_* packages, and the plumbing used to configure and
+// instantiate them.
+//
+// Register wraps every registered processor with Instrument so it reports to
+// the shared metrics Registry and supports WithTracer and WithName, without
+// each formatters/event_* package having to do so itself.
+package formatters
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/itchyny/gojq"
+	"github.com/mitchellh/mapstructure"
+	"github.com/openconfig/gnmic/types"
+)
+
+// EventMsg is the flattened representation of a gNMI update that
+// EventProcessors consume and produce.
+type EventMsg struct {
+	Name      string                 `json:"name,omitempty"`
+	Timestamp int64                  `json:"timestamp,omitempty"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+	Deletes   []string               `json:"deletes,omitempty"`
+}
+
+// EventProcessor transforms, filters or otherwise acts on a batch of
+// EventMsg. It is implemented by each formatters/event_* package.
+type EventProcessor interface {
+	Init(cfg interface{}, opts ...Option) error
+	Apply(es ...*EventMsg) []*EventMsg
+	WithLogger(l *log.Logger)
+	WithTargets(tcs map[string]*types.TargetConfig)
+	WithActions(act map[string]map[string]interface{})
+}
+
+// Option applies optional, non-mandatory configuration to an EventProcessor
+// at Init time.
+type Option func(EventProcessor)
+
+// WithLogger sets the logger used by the processor.
+func WithLogger(l *log.Logger) Option {
+	return func(p EventProcessor) {
+		p.WithLogger(l)
+	}
+}
+
+// WithTargets makes the targets configuration available to the processor.
+func WithTargets(tcs map[string]*types.TargetConfig) Option {
+	return func(p EventProcessor) {
+		p.WithTargets(tcs)
+	}
+}
+
+// WithActions makes the configured named actions available to the processor.
+func WithActions(acts map[string]map[string]interface{}) Option {
+	return func(p EventProcessor) {
+		p.WithActions(acts)
+	}
+}
+
+// Initializer builds a new, unconfigured EventProcessor instance.
+type Initializer func() EventProcessor
+
+var eventProcessors = map[string]Initializer{}
+
+// Register makes an EventProcessor Initializer available under name. It is
+// called from the init() function of each formatters/event_* package. The
+// registered Initializer is wrapped so every processor built from it reports
+// to the shared metrics Registry and supports WithTracer for free.
+func Register(name string, initFn Initializer) {
+	eventProcessors[name] = func() EventProcessor {
+		return Instrument(name, initFn())
+	}
+}
+
+// DecodeConfig decodes src, generally the raw processor configuration, into
+// dst using the `mapstructure` struct tags.
+func DecodeConfig(src, dst interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result: dst,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(src)
+}
+
+// CheckCondition evaluates the compiled jq code against e and reports
+// whether it evaluates to a truthy result.
+func CheckCondition(code *gojq.Code, e *EventMsg) (bool, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	var input interface{}
+	if err := json.Unmarshal(b, &input); err != nil {
+		return false, err
+	}
+	iter := code.Run(input)
+	v, ok := iter.Next()
+	if !ok {
+		return false, nil
+	}
+	if err, ok := v.(error); ok {
+		return false, err
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
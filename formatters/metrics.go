@@ -0,0 +1,196 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package formatters
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/openconfig/gnmic/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// targetTagName is the EventMsg tag carrying the originating target's name,
+// used to label the processor metrics below.
+const targetTagName = "source"
+
+// Registry is the shared Prometheus registry every EventProcessor reports
+// its metrics to. It can be exposed on a /metrics endpoint by the caller.
+var Registry = prometheus.NewRegistry()
+
+var (
+	eventsInTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gnmic",
+		Subsystem: "processor",
+		Name:      "events_in_total",
+		Help:      "number of events received by a processor",
+	}, []string{"type", "name", "target"})
+
+	eventsDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gnmic",
+		Subsystem: "processor",
+		Name:      "events_dropped_total",
+		Help:      "number of events dropped by a processor",
+	}, []string{"type", "name", "target", "reason"})
+
+	applyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gnmic",
+		Subsystem: "processor",
+		Name:      "apply_duration_seconds",
+		Help:      "duration of a processor's Apply call",
+	}, []string{"type", "name", "target"})
+)
+
+func init() {
+	Registry.MustRegister(eventsInTotal, eventsDroppedTotal, applyDuration)
+}
+
+// WithTracer injects a trace.Tracer into the processor, used to wrap Apply
+// calls in spans. Analogous to WithLogger, it is a no-op for processors that
+// don't opt into tracing.
+func WithTracer(t trace.Tracer) Option {
+	return func(p EventProcessor) {
+		if ts, ok := p.(interface{ WithTracer(trace.Tracer) }); ok {
+			ts.WithTracer(t)
+		}
+	}
+}
+
+// WithName sets the name a processor instance was configured under (as
+// opposed to its type), used to label its metrics. Analogous to WithLogger,
+// it is a no-op for processors that don't opt into naming themselves.
+func WithName(name string) Option {
+	return func(p EventProcessor) {
+		if ns, ok := p.(interface{ WithName(string) }); ok {
+			ns.WithName(name)
+		}
+	}
+}
+
+// ContextualApplier is implemented by processors that want the context of
+// the span instrumentedProcessor.Apply starts (when a tracer is configured),
+// so they can start their own span as its child instead of as a new root.
+// Apply is unchanged for processors that don't implement it.
+type ContextualApplier interface {
+	ApplyContext(ctx context.Context, es ...*EventMsg) []*EventMsg
+}
+
+// selfReportingDropReasons is implemented by processors that call
+// RecordDropReason themselves with a specific reason label. instrumentedProcessor
+// checks it so it doesn't also count the same drop under an empty reason.
+type selfReportingDropReasons interface {
+	SelfReportsDropReasons() bool
+}
+
+// RecordDropReason increments the events_dropped_total counter for a single
+// event dropped by processor ptype/name acting on target, labeled with
+// reason, and, if span is non-nil, records it as a span event too. Processors
+// that call this themselves must also implement selfReportingDropReasons so
+// instrumentedProcessor doesn't double-count the same drop.
+func RecordDropReason(ptype, name, target, reason string, span trace.Span) {
+	eventsDroppedTotal.WithLabelValues(ptype, name, target, reason).Inc()
+	if span != nil {
+		span.AddEvent("event dropped", trace.WithAttributes(
+			attribute.String("reason", reason),
+		))
+	}
+}
+
+// instrumentedProcessor wraps an EventProcessor so that every Apply call
+// reports to Registry and, when a tracer was injected via WithTracer, runs
+// inside a span.
+type instrumentedProcessor struct {
+	inner EventProcessor
+	ptype string
+	name  string
+
+	tracer trace.Tracer
+}
+
+// Instrument wraps p so its Apply calls are counted, timed, and optionally
+// traced. Register uses it so every registered processor type gets this for
+// free.
+func Instrument(ptype string, p EventProcessor) EventProcessor {
+	return &instrumentedProcessor{inner: p, ptype: ptype}
+}
+
+func (p *instrumentedProcessor) Init(cfg interface{}, opts ...Option) error {
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p.inner.Init(cfg, opts...)
+}
+
+func (p *instrumentedProcessor) Apply(es ...*EventMsg) []*EventMsg {
+	target := ""
+	if len(es) > 0 && es[0] != nil {
+		target = es[0].Tags[targetTagName]
+	}
+	eventsInTotal.WithLabelValues(p.ptype, p.name, target).Add(float64(len(es)))
+
+	ctx := context.Background()
+	var span trace.Span
+	if p.tracer != nil {
+		ctx, span = p.tracer.Start(ctx, "processor.Apply", trace.WithAttributes(
+			attribute.String("processor.type", p.ptype),
+			attribute.String("processor.name", p.name),
+			attribute.String("processor.target", target),
+		))
+		defer span.End()
+	}
+
+	start := time.Now()
+	var out []*EventMsg
+	if ca, ok := p.inner.(ContextualApplier); ok {
+		out = ca.ApplyContext(ctx, es...)
+	} else {
+		out = p.inner.Apply(es...)
+	}
+	applyDuration.WithLabelValues(p.ptype, p.name, target).Observe(time.Since(start).Seconds())
+
+	if dropped := len(es) - len(out); dropped > 0 {
+		if sr, ok := p.inner.(selfReportingDropReasons); !ok || !sr.SelfReportsDropReasons() {
+			eventsDroppedTotal.WithLabelValues(p.ptype, p.name, target, "").Add(float64(dropped))
+		}
+	}
+	return out
+}
+
+func (p *instrumentedProcessor) WithLogger(l *log.Logger) { p.inner.WithLogger(l) }
+
+func (p *instrumentedProcessor) WithTargets(tcs map[string]*types.TargetConfig) {
+	p.inner.WithTargets(tcs)
+}
+
+func (p *instrumentedProcessor) WithActions(act map[string]map[string]interface{}) {
+	p.inner.WithActions(act)
+}
+
+// WithTracer captures the injected tracer so Apply can wrap itself in spans.
+// It is applied once, at Init time, alongside the other Options - never from
+// within Apply itself, which would race with the inner processor reading its
+// own tracer field concurrently.
+func (p *instrumentedProcessor) WithTracer(t trace.Tracer) {
+	p.tracer = t
+}
+
+// WithName captures the processor instance's configured name for use in its
+// metric labels.
+func (p *instrumentedProcessor) WithName(name string) {
+	p.name = name
+}
@@ -0,0 +1,99 @@
+// Copyright © 2020 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package formatters
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/openconfig/gnmic/types"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeProcessor is a minimal EventProcessor used to exercise the
+// instrumentedProcessor wrapper without depending on a real
+// formatters/event_* package.
+type fakeProcessor struct {
+	tracer trace.Tracer
+	name   string
+
+	gotCtx context.Context
+}
+
+func (f *fakeProcessor) Init(cfg interface{}, opts ...Option) error        { return nil }
+func (f *fakeProcessor) Apply(es ...*EventMsg) []*EventMsg                 { return es }
+func (f *fakeProcessor) WithLogger(l *log.Logger)                          {}
+func (f *fakeProcessor) WithTargets(tcs map[string]*types.TargetConfig)    {}
+func (f *fakeProcessor) WithActions(act map[string]map[string]interface{}) {}
+
+func (f *fakeProcessor) WithTracer(t trace.Tracer) { f.tracer = t }
+func (f *fakeProcessor) WithName(name string)      { f.name = name }
+
+func (f *fakeProcessor) ApplyContext(ctx context.Context, es ...*EventMsg) []*EventMsg {
+	f.gotCtx = ctx
+	return es
+}
+
+// TestInstrument_WithTracerAndWithName checks that WithTracer/WithName, when
+// passed to Init, are captured by the instrumentedProcessor wrapper itself:
+// its tracer field (used to start the parent span) and its name field (used
+// in its metric labels).
+func TestInstrument_WithTracerAndWithName(t *testing.T) {
+	inner := &fakeProcessor{}
+	p := Instrument("fake", inner)
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	if err := p.Init(nil, WithTracer(tracer), WithName("my-fake")); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	ip, ok := p.(*instrumentedProcessor)
+	if !ok {
+		t.Fatalf("expected Instrument to return *instrumentedProcessor, got %T", p)
+	}
+	if ip.tracer == nil {
+		t.Fatalf("expected WithTracer to set instrumentedProcessor.tracer")
+	}
+	if ip.name != "my-fake" {
+		t.Fatalf("expected WithName to set instrumentedProcessor.name, got %q", ip.name)
+	}
+}
+
+// TestInstrument_ApplyParentsSpanOnContextualApplier checks that, when the
+// inner processor implements ContextualApplier, instrumentedProcessor.Apply
+// hands it the context carrying its own span, instead of the inner processor
+// having to start an unrelated root span via context.Background().
+func TestInstrument_ApplyParentsSpanOnContextualApplier(t *testing.T) {
+	inner := &fakeProcessor{}
+	p := Instrument("fake", inner)
+	// a real TracerProvider is required here: the noop one's spans carry an
+	// invalid SpanContext by design, so they could never make the IsValid
+	// assertion below meaningful.
+	tracer := sdktrace.NewTracerProvider().Tracer("test")
+	if err := p.Init(nil, WithTracer(tracer)); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	p.Apply(&EventMsg{Tags: map[string]string{targetTagName: "t1"}})
+
+	if inner.gotCtx == nil {
+		t.Fatalf("expected ApplyContext to receive a non-nil context")
+	}
+	if !trace.SpanContextFromContext(inner.gotCtx).IsValid() {
+		t.Fatalf("expected the context passed to ApplyContext to carry the wrapper's span")
+	}
+}